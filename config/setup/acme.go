@@ -0,0 +1,266 @@
+package setup
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+)
+
+// acmeDefaultCA is the ACME directory endpoint used when no "ca" sub-directive
+// is given in the tls block.
+const acmeDefaultCA = "https://acme-v02.api.letsencrypt.org/directory"
+
+// acmeRenewalWindow is how far ahead of a certificate's expiry Caddy attempts
+// to renew it.
+const acmeRenewalWindow = 30 * 24 * time.Hour
+
+// acmeRenewals tracks the renewal goroutine running for each managed host,
+// so that re-activating ACME for a host (e.g. a live config reload) stops
+// the previous goroutine instead of leaking another one.
+var (
+	acmeRenewalsMu sync.Mutex
+	acmeRenewals   = make(map[string]chan struct{})
+)
+
+// acmeStartRenewal stops any renewal goroutine already running for host and
+// returns a fresh stop channel for the one about to replace it.
+func acmeStartRenewal(host string) chan struct{} {
+	acmeRenewalsMu.Lock()
+	defer acmeRenewalsMu.Unlock()
+	if stop, ok := acmeRenewals[host]; ok {
+		close(stop)
+	}
+	stop := make(chan struct{})
+	acmeRenewals[host] = stop
+	return stop
+}
+
+// acmeSetCertFiles updates c.TLS.Certificate/Key under c.TLS.CertMu, since
+// the renewal goroutine writes these fields while request handling (and a
+// future config reload) reads them concurrently.
+func acmeSetCertFiles(c *Controller, certFile, keyFile string) {
+	c.TLS.CertMu.Lock()
+	c.TLS.Certificate = certFile
+	c.TLS.Key = keyFile
+	c.TLS.CertMu.Unlock()
+}
+
+// activateACME obtains (or loads a cached) certificate for c.Host via ACME
+// and sets c.TLS.Certificate/c.TLS.Key to the files it's stored in. It also
+// starts a background goroutine that keeps the certificate renewed for as
+// long as the process runs.
+func activateACME(c *Controller) error {
+	caURL := c.TLS.ACMECAUrl
+	if caURL == "" {
+		caURL = acmeDefaultCA
+	}
+
+	storageDir, err := acmeStorageDir(caURL, c.TLS.ACMEEmail)
+	if err != nil {
+		return err
+	}
+
+	certFile := filepath.Join(storageDir, c.Host+".crt")
+	keyFile := filepath.Join(storageDir, c.Host+".key")
+
+	if !acmeCertNeedsRenewal(certFile) {
+		acmeSetCertFiles(c, certFile, keyFile)
+		go acmeRenewLoop(c, certFile, acmeStartRenewal(c.Host))
+		return nil
+	}
+
+	user, err := acmeLoadOrRegisterUser(caURL, storageDir, c.TLS.ACMEEmail)
+	if err != nil {
+		return err
+	}
+
+	client, err := acme.NewClient(caURL, user, acme.RSA2048)
+	if err != nil {
+		return err
+	}
+	// Answer the HTTP-01 challenge through this host's own middleware chain
+	// (see acmeHTTPProvider), falling back to a standalone :80 listener only
+	// if nothing else is there to serve it.
+	client.SetChallengeProvider(acme.HTTP01, acmeProviderFor(c.Host))
+	client.ExcludeChallenges([]acme.Challenge{acme.TLSALPN01, acme.DNS01})
+
+	certificate, failures := client.ObtainCertificate([]string{c.Host}, true, nil, false)
+	if len(failures) > 0 {
+		return failures[c.Host]
+	}
+
+	if err := os.MkdirAll(storageDir, 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(certFile, certificate.Certificate, 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(keyFile, certificate.PrivateKey, 0600); err != nil {
+		return err
+	}
+
+	acmeSetCertFiles(c, certFile, keyFile)
+
+	go acmeRenewLoop(c, certFile, acmeStartRenewal(c.Host))
+
+	return nil
+}
+
+// acmeRenewLoop periodically checks the managed certificate at certFile and
+// re-activates ACME for c.Host once it's within acmeRenewalWindow of expiring.
+// It exits as soon as stop is closed, which activateACME does whenever it
+// starts a fresh renewal goroutine for the same host (a successful renewal,
+// or a config reload re-running the tls directive).
+func acmeRenewLoop(c *Controller, certFile string, stop chan struct{}) {
+	ticker := time.NewTicker(12 * time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if !acmeCertNeedsRenewal(certFile) {
+			continue
+		}
+		if err := activateACME(c); err != nil {
+			log.Printf("[ERROR] Renewing certificate for %s: %v", c.Host, err)
+			continue
+		}
+		// activateACME already started our replacement; we're done.
+		return
+	}
+}
+
+// acmeCertNeedsRenewal reports whether the certificate at certFile is
+// missing, unparsable, or within acmeRenewalWindow of expiring.
+func acmeCertNeedsRenewal(certFile string) bool {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return true
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return true
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return true
+	}
+	return time.Until(cert.NotAfter) < acmeRenewalWindow
+}
+
+// acmeStorageDir returns (and creates, if necessary) the directory used to
+// cache the ACME account and certificates for the given CA and email.
+func acmeStorageDir(caURL, email string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	ca, err := url.Parse(caURL)
+	if err != nil {
+		return "", err
+	}
+	if email == "" {
+		email = "default"
+	}
+	dir := filepath.Join(home, ".caddy", "acme", ca.Host, email)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// acmeUser implements acme.User, backed by an account key and registration
+// cached on disk under storageDir so repeated runs reuse the same account.
+type acmeUser struct {
+	Email        string
+	Registration *acme.RegistrationResource
+	key          *rsa.PrivateKey
+}
+
+func (u *acmeUser) GetEmail() string                            { return u.Email }
+func (u *acmeUser) GetRegistration() *acme.RegistrationResource { return u.Registration }
+func (u *acmeUser) GetPrivateKey() *rsa.PrivateKey              { return u.key }
+
+// acmeLoadOrRegisterUser loads a cached ACME account from storageDir, or
+// registers a new one with the CA and agrees to its terms of service.
+func acmeLoadOrRegisterUser(caURL, storageDir, email string) (*acmeUser, error) {
+	keyFile := filepath.Join(storageDir, "account.key")
+	regFile := filepath.Join(storageDir, "account.json")
+
+	key, err := acmeLoadOrGenerateKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &acmeUser{Email: email, key: key}
+
+	if regBytes, err := ioutil.ReadFile(regFile); err == nil {
+		var reg acme.RegistrationResource
+		if err := json.Unmarshal(regBytes, &reg); err != nil {
+			return nil, err
+		}
+		user.Registration = &reg
+		return user, nil
+	}
+
+	client, err := acme.NewClient(caURL, user, acme.RSA2048)
+	if err != nil {
+		return nil, err
+	}
+	reg, err := client.Register()
+	if err != nil {
+		return nil, err
+	}
+	if err := client.AgreeToTOS(); err != nil {
+		return nil, err
+	}
+	user.Registration = reg
+
+	regBytes, err := json.Marshal(reg)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(regFile, regBytes, 0600); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// acmeLoadOrGenerateKey loads the PEM-encoded RSA private key at keyFile,
+// generating and persisting a new one if it doesn't exist yet.
+func acmeLoadOrGenerateKey(keyFile string) (*rsa.PrivateKey, error) {
+	if keyBytes, err := ioutil.ReadFile(keyFile); err == nil {
+		block, _ := pem.Decode(keyBytes)
+		if block == nil {
+			return nil, errors.New("invalid ACME account key PEM in " + keyFile)
+		}
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
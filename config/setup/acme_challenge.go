@@ -0,0 +1,110 @@
+package setup
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/mholt/caddy/middleware"
+)
+
+// acmeChallengePath is the well-known URL prefix the ACME CA requests while
+// performing an HTTP-01 challenge.
+const acmeChallengePath = "/.well-known/acme-challenge/"
+
+// acmeChallenges is the single, process-wide HTTP-01 provider shared by
+// every managed host. ACME already guarantees tokens are globally unique,
+// so there's no need to key anything by host - doing so would mean only
+// one host's fallback listener ever wins the ":80" bind, leaving every
+// other managed host answering with its own (empty) token map and failing
+// issuance.
+var acmeChallenges = &acmeHTTPProvider{tokens: make(map[string]string)}
+
+// acmeProviderFor returns the shared HTTP-01 challenge provider. host is
+// unused beyond documenting the call site's intent; the provider itself is
+// process-wide.
+func acmeProviderFor(host string) *acmeHTTPProvider {
+	return acmeChallenges
+}
+
+// acmeHTTPProvider implements acme.ChallengeProvider by answering HTTP-01
+// challenges through Caddy's own HTTP listener, via the middleware it
+// returns. On the first challenge it is given it also tries, once for the
+// whole process, to bind :80 itself; that listener is only set up if nothing
+// else is listening on :80 at that moment.
+type acmeHTTPProvider struct {
+	mu     sync.Mutex
+	tokens map[string]string // token -> key authorization
+
+	fallbackOnce     sync.Once
+	fallbackListener net.Listener
+}
+
+// Present implements acme.ChallengeProvider.
+func (p *acmeHTTPProvider) Present(domain, token, keyAuth string) error {
+	p.mu.Lock()
+	p.tokens[token] = keyAuth
+	p.mu.Unlock()
+	p.fallbackOnce.Do(p.startFallbackListener)
+	return nil
+}
+
+// CleanUp implements acme.ChallengeProvider.
+func (p *acmeHTTPProvider) CleanUp(domain, token, keyAuth string) error {
+	p.mu.Lock()
+	delete(p.tokens, token)
+	p.mu.Unlock()
+	return nil
+}
+
+// Middleware returns the middleware that answers HTTP-01 challenge requests
+// arriving on a host's own listener, passing everything else through.
+func (p *acmeHTTPProvider) Middleware() middleware.Middleware {
+	return func(next middleware.Handler) middleware.Handler {
+		return middleware.HandlerFunc(func(w http.ResponseWriter, r *http.Request) (int, error) {
+			keyAuth, ok := p.lookup(r.URL.Path)
+			if !ok {
+				return next.ServeHTTP(w, r)
+			}
+			w.Write([]byte(keyAuth))
+			return http.StatusOK, nil
+		})
+	}
+}
+
+// lookup returns the key authorization for the token encoded in path, if
+// path is an ACME HTTP-01 challenge request for a token we know about.
+func (p *acmeHTTPProvider) lookup(path string) (string, bool) {
+	if !strings.HasPrefix(path, acmeChallengePath) {
+		return "", false
+	}
+	token := strings.TrimPrefix(path, acmeChallengePath)
+	p.mu.Lock()
+	keyAuth, ok := p.tokens[token]
+	p.mu.Unlock()
+	return keyAuth, ok
+}
+
+// startFallbackListener binds :80 itself only if nothing is already
+// listening there. This covers the case where no managed host's middleware
+// chain is ever reached on :80 - e.g. no plain-http site block exists to
+// serve the challenge through Caddy's normal listener. It runs at most once
+// per process, regardless of how many hosts are managed.
+func (p *acmeHTTPProvider) startFallbackListener() {
+	ln, err := net.Listen("tcp", ":80")
+	if err != nil {
+		// Something else already owns :80 - presumably Caddy's own
+		// listener(s), which will reach this provider through Middleware().
+		return
+	}
+	p.fallbackListener = ln
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keyAuth, ok := p.lookup(r.URL.Path)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(keyAuth))
+	}))
+}
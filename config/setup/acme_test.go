@@ -0,0 +1,92 @@
+package setup
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLooksLikeEmail(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"user@example.com", true},
+		{"a@b.co", true},
+		{"auto", false},
+		{"@example.com", false},
+		{"user@", false},
+		{"user@localhost", false},
+		{"/etc/ssl/certs/site.pem", false},
+	}
+	for _, tt := range tests {
+		if got := looksLikeEmail(tt.in); got != tt.want {
+			t.Errorf("looksLikeEmail(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestAcmeCertNeedsRenewal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "caddy-acme-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if !acmeCertNeedsRenewal(filepath.Join(dir, "missing.crt")) {
+		t.Error("a missing certificate should need renewal")
+	}
+
+	garbage := filepath.Join(dir, "garbage.crt")
+	if err := ioutil.WriteFile(garbage, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if !acmeCertNeedsRenewal(garbage) {
+		t.Error("an unparsable certificate should need renewal")
+	}
+
+	fresh := filepath.Join(dir, "fresh.crt")
+	writeTestCert(t, fresh, 60*24*time.Hour)
+	if acmeCertNeedsRenewal(fresh) {
+		t.Error("a certificate expiring in 60 days should not need renewal yet")
+	}
+
+	expiring := filepath.Join(dir, "expiring.crt")
+	writeTestCert(t, expiring, time.Hour)
+	if !acmeCertNeedsRenewal(expiring) {
+		t.Error("a certificate expiring in 1 hour should need renewal")
+	}
+}
+
+// writeTestCert writes a self-signed certificate valid for validFor to path.
+func writeTestCert(t *testing.T, path string, validFor time.Duration) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "caddy-acme-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validFor),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(path, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,6 +2,8 @@ package setup
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
 	"log"
 	"strconv"
 	"strings"
@@ -24,12 +26,23 @@ func TLS(c *Controller) (middleware.Middleware, error) {
 		if !c.NextArg() {
 			return nil, c.ArgErr()
 		}
-		c.TLS.Certificate = c.Val()
+		first := c.Val()
 
-		if !c.NextArg() {
-			return nil, c.ArgErr()
+		if first == "auto" || looksLikeEmail(first) {
+			// "tls auto" or "tls user@example.com": manage the certificate
+			// automatically via ACME instead of loading it from disk.
+			c.TLS.Managed = true
+			if first != "auto" {
+				c.TLS.ACMEEmail = first
+			}
+		} else {
+			c.TLS.Certificate = first
+
+			if !c.NextArg() {
+				return nil, c.ArgErr()
+			}
+			c.TLS.Key = c.Val()
 		}
-		c.TLS.Key = c.Val()
 
 		// Optional block
 		for c.NextBlock() {
@@ -51,16 +64,46 @@ func TLS(c *Controller) (middleware.Middleware, error) {
 				}
 				c.TLS.ProtocolMaxVersion = value
 			case "ciphers":
+				c.TLS.Ciphers = c.TLS.Ciphers[:0]
 				for c.NextArg() {
 					value, ok := supportedCiphers[strings.ToUpper(c.Val())]
 					if !ok {
 						return nil, c.Errf("Wrong cipher name or cipher not supported '%s'", c.Val())
 					}
-					if _, ok := http2CipherSuites[value]; app.Http2 && !ok {
+					if _, bad := http2CipherSuites[value]; app.Http2 && bad {
 						return nil, c.Errf("Cipher suite %s is not allowed for HTTP/2", c.Val())
 					}
 					c.TLS.Ciphers = append(c.TLS.Ciphers, value)
 				}
+			case "profile":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				profile, ok := supportedProfiles[strings.ToLower(c.Val())]
+				if !ok {
+					return nil, c.Errf("Wrong profile name or profile not supported '%s'", c.Val())
+				}
+				c.TLS.ProtocolMinVersion = profile.protocolMinVersion
+				c.TLS.ProtocolMaxVersion = profile.protocolMaxVersion
+				// Same HTTP/2 restriction as the manual "ciphers" directive,
+				// but filtered rather than rejected outright: profiles like
+				// "intermediate"/"old" are full of CBC suites for broad
+				// compatibility, and still have HTTP/2-safe suites worth
+				// keeping when app.Http2 is set.
+				c.TLS.Ciphers = filterHTTP2Ciphers(profile.ciphers, app.Http2)
+				c.TLS.PreferServerCipherSuites = profile.preferServerCipherSuites
+			case "prefer_server_ciphers":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				switch strings.ToLower(c.Val()) {
+				case "on":
+					c.TLS.PreferServerCipherSuites = true
+				case "off":
+					c.TLS.PreferServerCipherSuites = false
+				default:
+					return nil, c.Errf("Wrong value for prefer_server_ciphers '%s', expected 'on' or 'off'", c.Val())
+				}
 			case "cache":
 				if !c.NextArg() {
 					return nil, c.ArgErr()
@@ -70,6 +113,45 @@ func TLS(c *Controller) (middleware.Middleware, error) {
 					return nil, c.Errf("Cache parameter must be an number '%s': %v", c.Val(), err)
 				}
 				c.TLS.CacheSize = size
+			case "clients":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.ArgErr()
+				}
+				authType, ok := supportedClientAuth[strings.ToLower(args[0])]
+				if !ok {
+					return nil, c.Errf("Wrong client authentication type '%s'", args[0])
+				}
+				c.TLS.ClientAuth = authType
+				if authType != tls.RequestClientCert {
+					if len(args) < 2 {
+						return nil, c.Errf("Client certificate authentication '%s' requires at least one CA certificate", args[0])
+					}
+					pool := x509.NewCertPool()
+					for _, caFile := range args[1:] {
+						caCrt, err := ioutil.ReadFile(caFile)
+						if err != nil {
+							return nil, c.Errf("Could not read CA certificate '%s': %v", caFile, err)
+						}
+						if !pool.AppendCertsFromPEM(caCrt) {
+							return nil, c.Errf("Could not parse CA certificate '%s'", caFile)
+						}
+					}
+					c.TLS.ClientCerts = pool
+				}
+			case "curves":
+				for c.NextArg() {
+					value, ok := supportedCurves[strings.ToLower(c.Val())]
+					if !ok {
+						return nil, c.Errf("Wrong curve name or curve not supported '%s'", c.Val())
+					}
+					c.TLS.CurvePreferences = append(c.TLS.CurvePreferences, value)
+				}
+			case "ca":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				c.TLS.ACMECAUrl = c.Val()
 			default:
 				return nil, c.Errf("Unknown keyword '%s'")
 			}
@@ -79,7 +161,7 @@ func TLS(c *Controller) (middleware.Middleware, error) {
 	// If no ciphers provided, use all that Caddy supports for the protocol
 	if len(c.TLS.Ciphers) == 0 {
 		for _, v := range supportedCiphers {
-			if _, ok := http2CipherSuites[v]; !app.Http2 || ok {
+			if _, bad := http2CipherSuites[v]; !app.Http2 || !bad {
 				c.TLS.Ciphers = append(c.TLS.Ciphers, v)
 			}
 		}
@@ -100,9 +182,46 @@ func TLS(c *Controller) (middleware.Middleware, error) {
 		c.TLS.CacheSize = 64
 	}
 
+	// If no curves provided, use the default Caddy preference
+	if len(c.TLS.CurvePreferences) == 0 {
+		c.TLS.CurvePreferences = []tls.CurveID{tls.X25519, tls.CurveP256}
+	}
+
+	// If this host's certificate is managed by ACME, obtain (or renew) it now
+	// and keep it fresh in the background for as long as the server runs.
+	// The returned middleware answers the HTTP-01 challenge through this
+	// host's own handler chain; see acmeHTTPProvider.
+	if c.TLS.Managed {
+		if err := activateACME(c); err != nil {
+			return nil, c.Errf("Could not activate ACME for %s: %v", c.Host, err)
+		}
+		return acmeProviderFor(c.Host).Middleware(), nil
+	}
+
 	return nil, nil
 }
 
+// filterHTTP2Ciphers returns ciphers with every HTTP/2-blacklisted suite
+// removed when http2 is true; it returns a copy of ciphers unchanged
+// otherwise.
+func filterHTTP2Ciphers(ciphers []uint16, http2 bool) []uint16 {
+	filtered := make([]uint16, 0, len(ciphers))
+	for _, cipher := range ciphers {
+		if _, bad := http2CipherSuites[cipher]; http2 && bad {
+			continue
+		}
+		filtered = append(filtered, cipher)
+	}
+	return filtered
+}
+
+// looksLikeEmail returns whether s looks enough like an email address to be
+// used as an ACME account contact, as opposed to a certificate file path.
+func looksLikeEmail(s string) bool {
+	at := strings.Index(s, "@")
+	return at > 0 && strings.Contains(s[at+1:], ".")
+}
+
 // Map of supported protocols
 // SSLv3 will be not supported in next release
 // HTTP/2 only supports TLS 1.2 and higher
@@ -113,13 +232,89 @@ var supportedProtocols = map[string]uint16{
 	"tls1.2": tls.VersionTLS12,
 }
 
+// tlsProfile is a named, pre-baked combination of protocol versions,
+// cipher suites and cipher suite ordering, mirroring the Mozilla SSL
+// configuration generator tiers.
+type tlsProfile struct {
+	protocolMinVersion       uint16
+	protocolMaxVersion       uint16
+	ciphers                  []uint16
+	preferServerCipherSuites bool
+}
+
+// Map of supported TLS profiles. Any of "ciphers" or "protocols" appearing
+// after "profile" in the same block overrides what the profile set.
+var supportedProfiles = map[string]tlsProfile{
+	"modern": {
+		protocolMinVersion: tls.VersionTLS12,
+		protocolMaxVersion: tls.VersionTLS12,
+		ciphers: []uint16{
+			supportedCiphers["ECDHE-ECDSA-CHACHA20-POLY1305"],
+			supportedCiphers["ECDHE-RSA-CHACHA20-POLY1305"],
+			supportedCiphers["ECDHE-ECDSA-AES128-GCM-SHA256"],
+			supportedCiphers["ECDHE-RSA-AES128-GCM-SHA256"],
+			supportedCiphers["ECDHE-ECDSA-AES256-GCM-SHA384"],
+			supportedCiphers["ECDHE-RSA-AES256-GCM-SHA384"],
+		},
+		preferServerCipherSuites: true,
+	},
+	"intermediate": {
+		protocolMinVersion: tls.VersionTLS10,
+		protocolMaxVersion: tls.VersionTLS12,
+		ciphers: []uint16{
+			supportedCiphers["ECDHE-RSA-AES128-GCM-SHA256"],
+			supportedCiphers["ECDHE-ECDSA-AES128-GCM-SHA256"],
+			supportedCiphers["ECDHE-RSA-AES128-CBC-SHA"],
+			supportedCiphers["ECDHE-ECDSA-AES128-CBC-SHA"],
+			supportedCiphers["ECDHE-RSA-AES256-CBC-SHA"],
+			supportedCiphers["ECDHE-ECDSA-AES256-CBC-SHA"],
+		},
+		preferServerCipherSuites: true,
+	},
+	"old": {
+		protocolMinVersion: tls.VersionSSL30,
+		protocolMaxVersion: tls.VersionTLS12,
+		ciphers: []uint16{
+			supportedCiphers["ECDHE-RSA-AES128-GCM-SHA256"],
+			supportedCiphers["ECDHE-ECDSA-AES128-GCM-SHA256"],
+			supportedCiphers["ECDHE-RSA-AES128-CBC-SHA"],
+			supportedCiphers["ECDHE-ECDSA-AES128-CBC-SHA"],
+			supportedCiphers["ECDHE-RSA-AES256-CBC-SHA"],
+			supportedCiphers["ECDHE-ECDSA-AES256-CBC-SHA"],
+			supportedCiphers["RSA-AES128-CBC-SHA"],
+			supportedCiphers["RSA-AES256-CBC-SHA"],
+			supportedCiphers["ECDHE-RSA-3DES-EDE-CBC-SHA"],
+			supportedCiphers["RSA-3DES-EDE-CBC-SHA"],
+		},
+		preferServerCipherSuites: false,
+	},
+}
+
+// Map of supported elliptic curves.
+var supportedCurves = map[string]tls.CurveID{
+	"x25519": tls.X25519,
+	"p256":   tls.CurveP256,
+	"p384":   tls.CurveP384,
+	"p521":   tls.CurveP521,
+}
+
+// Map of supported client authentication types.
+var supportedClientAuth = map[string]tls.ClientAuthType{
+	"request":         tls.RequestClientCert,
+	"require":         tls.RequireAndVerifyClientCert,
+	"verify_if_given": tls.VerifyClientCertIfGiven,
+}
+
 // Map of supported ciphers.
-//
-// Note that, at time of writing, HTTP/2 blacklists 276 cipher suites,
-// including all but two of the suites below (the two GCM suites).
 var supportedCiphers = map[string]uint16{
 	"ECDHE-RSA-AES128-GCM-SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
 	"ECDHE-ECDSA-AES128-GCM-SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"ECDHE-RSA-AES256-GCM-SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"ECDHE-ECDSA-AES256-GCM-SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"ECDHE-RSA-CHACHA20-POLY1305":   tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"ECDHE-ECDSA-CHACHA20-POLY1305": tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	"ECDHE-RSA-AES128-CBC-SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+	"ECDHE-ECDSA-AES128-CBC-SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
 	"ECDHE-RSA-AES128-CBC-SHA":      tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
 	"ECDHE-RSA-AES256-CBC-SHA":      tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
 	"ECDHE-ECDSA-AES256-CBC-SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
@@ -130,9 +325,22 @@ var supportedCiphers = map[string]uint16{
 	"RSA-3DES-EDE-CBC-SHA":          tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
 }
 
-// Set of cipher suites not blacklisted by HTTP/2 spec.
-// See https://http2.github.io/http2-spec/#BadCipherSuites
+// Set of cipher suites blacklisted by the HTTP/2 spec.
+// See https://http2.github.io/http2-spec/#BadCipherSuites (RFC 7540 Appendix A).
+// It lists the blacklisted suites Caddy knows of (RSA key exchange, CBC mode,
+// 3DES); anything not listed here (the ECDHE AEAD suites) is fair game for
+// HTTP/2.
 var http2CipherSuites = map[uint16]struct{}{
-	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256:   struct{}{},
-	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256: struct{}{},
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA:            {},
+	tls.TLS_RSA_WITH_AES_256_CBC_SHA:            {},
+	tls.TLS_RSA_WITH_AES_128_GCM_SHA256:         {},
+	tls.TLS_RSA_WITH_AES_256_GCM_SHA384:         {},
+	tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA:           {},
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA:      {},
+	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA:      {},
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA:    {},
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA:    {},
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256:   {},
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256: {},
+	tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA:     {},
 }
@@ -0,0 +1,108 @@
+package setup
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestSupportedClientAuth(t *testing.T) {
+	tests := []struct {
+		name string
+		want tls.ClientAuthType
+	}{
+		{"request", tls.RequestClientCert},
+		{"require", tls.RequireAndVerifyClientCert},
+		{"verify_if_given", tls.VerifyClientCertIfGiven},
+	}
+	for _, tt := range tests {
+		got, ok := supportedClientAuth[tt.name]
+		if !ok {
+			t.Errorf("supportedClientAuth[%q] missing", tt.name)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("supportedClientAuth[%q] = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, ok := supportedClientAuth["bogus"]; ok {
+		t.Error(`supportedClientAuth["bogus"] should not exist`)
+	}
+}
+
+func TestFilterHTTP2Ciphers(t *testing.T) {
+	ciphers := []uint16{
+		supportedCiphers["ECDHE-RSA-AES128-GCM-SHA256"],
+		supportedCiphers["ECDHE-RSA-AES128-CBC-SHA"],
+	}
+
+	got := filterHTTP2Ciphers(ciphers, false)
+	if len(got) != len(ciphers) {
+		t.Fatalf("with HTTP/2 disabled, expected all %d ciphers kept, got %d", len(ciphers), len(got))
+	}
+
+	got = filterHTTP2Ciphers(ciphers, true)
+	if len(got) != 1 || got[0] != ciphers[0] {
+		t.Fatalf("with HTTP/2 enabled, expected only the GCM cipher kept, got %v", got)
+	}
+}
+
+func TestHTTP2CipherBlacklist(t *testing.T) {
+	allowed := []string{
+		"ECDHE-RSA-AES128-GCM-SHA256",
+		"ECDHE-ECDSA-AES128-GCM-SHA256",
+		"ECDHE-RSA-AES256-GCM-SHA384",
+		"ECDHE-ECDSA-AES256-GCM-SHA384",
+		"ECDHE-RSA-CHACHA20-POLY1305",
+		"ECDHE-ECDSA-CHACHA20-POLY1305",
+	}
+	for _, name := range allowed {
+		cipher, ok := supportedCiphers[name]
+		if !ok {
+			t.Fatalf("supportedCiphers[%q] missing", name)
+		}
+		if _, bad := http2CipherSuites[cipher]; bad {
+			t.Errorf("%s should not be blacklisted for HTTP/2", name)
+		}
+	}
+
+	blacklisted := []string{
+		"RSA-AES128-CBC-SHA",
+		"RSA-3DES-EDE-CBC-SHA",
+		"ECDHE-RSA-AES128-CBC-SHA",
+		"ECDHE-RSA-AES128-CBC-SHA256",
+		"ECDHE-RSA-3DES-EDE-CBC-SHA",
+	}
+	for _, name := range blacklisted {
+		cipher, ok := supportedCiphers[name]
+		if !ok {
+			t.Fatalf("supportedCiphers[%q] missing", name)
+		}
+		if _, bad := http2CipherSuites[cipher]; !bad {
+			t.Errorf("%s should be blacklisted for HTTP/2", name)
+		}
+	}
+}
+
+func TestSupportedCurves(t *testing.T) {
+	tests := map[string]tls.CurveID{
+		"x25519": tls.X25519,
+		"p256":   tls.CurveP256,
+		"p384":   tls.CurveP384,
+		"p521":   tls.CurveP521,
+	}
+	for name, want := range tests {
+		got, ok := supportedCurves[name]
+		if !ok {
+			t.Errorf("supportedCurves[%q] missing", name)
+			continue
+		}
+		if got != want {
+			t.Errorf("supportedCurves[%q] = %v, want %v", name, got, want)
+		}
+	}
+
+	if _, ok := supportedCurves["p512"]; ok {
+		t.Error(`supportedCurves["p512"] should not exist`)
+	}
+}